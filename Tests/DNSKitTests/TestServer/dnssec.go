@@ -0,0 +1,360 @@
+/*
+DNSKit
+Copyright (C) Ian Spence and other DNSKit Contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Lesser General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Lesser General Public License for more details.
+
+You should have received a copy of the GNU Lesser General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS names used to exercise DNSSEC validation on the client
+const (
+	TestNameDNSSECValid        = "dnssec.valid.example.com."
+	TestNameDNSSECBadSig       = "dnssec.badsig.example.com."
+	TestNameDNSSECExpired      = "dnssec.expired.example.com."
+	TestNameDNSSECWrongSigner  = "dnssec.wrongsigner.example.com."
+	TestNameDNSSECNSEC3Missing = "dnssec.nsec3.missing.example.com."
+)
+
+// DNSSEC resource record types not predefined by dnsmessage
+const (
+	dnsTypeRRSIG  = dnsmessage.Type(46)
+	dnsTypeNSEC   = dnsmessage.Type(47)
+	dnsTypeDNSKEY = dnsmessage.Type(48)
+	dnsTypeDS     = dnsmessage.Type(43)
+	dnsTypeNSEC3  = dnsmessage.Type(50)
+)
+
+// dnssecAlgorithm mirrors the IANA DNSSEC algorithm numbers relevant to this server
+type dnssecAlgorithm uint8
+
+const (
+	dnssecAlgorithmRSASHA256 dnssecAlgorithm = 8
+	dnssecAlgorithmED25519   dnssecAlgorithm = 15
+)
+
+// dnssecAlgorithmFromString maps the --dnssec-algo flag value to an algorithm number
+func dnssecAlgorithmFromString(s string) (dnssecAlgorithm, error) {
+	switch strings.ToLower(s) {
+	case "", "ed25519":
+		return dnssecAlgorithmED25519, nil
+	case "rsasha256", "rsa":
+		return dnssecAlgorithmRSASHA256, nil
+	default:
+		return 0, fmt.Errorf("unknown DNSSEC algorithm %q", s)
+	}
+}
+
+const (
+	dnssecZoneName    = "example.com."
+	dnssecWrongSigner = "wrong-signer.example.com."
+)
+
+var (
+	dnssecAlgo dnssecAlgorithm
+	zskSigner  crypto.Signer
+	kskSigner  crypto.Signer
+	zskRDATA   []byte
+	kskRDATA   []byte
+	zskTag     uint16
+	kskTag     uint16
+	dsRDATA    []byte
+)
+
+// initDNSSEC generates a fresh ZSK/KSK pair in the algorithm selected by --dnssec-algo and
+// publishes the resulting DNSKEY/DS records for the zone apex, mirroring how loadRoot
+// provisions the TLS root used by the other transports.
+func initDNSSEC(algo dnssecAlgorithm) error {
+	dnssecAlgo = algo
+
+	zskPub, zskPriv, err := newDNSSECKeyPair(algo)
+	if err != nil {
+		return err
+	}
+	kskPub, kskPriv, err := newDNSSECKeyPair(algo)
+	if err != nil {
+		return err
+	}
+
+	zskSigner = zskPriv
+	kskSigner = kskPriv
+	zskRDATA = dnskeyRDATA(256, algo, zskPub)
+	kskRDATA = dnskeyRDATA(257, algo, kskPub)
+	zskTag = dnskeyKeyTag(zskRDATA)
+	kskTag = dnskeyKeyTag(kskRDATA)
+	dsRDATA = dsRDATAFromDNSKEY(dnssecZoneName, kskRDATA)
+
+	return nil
+}
+
+func newDNSSECKeyPair(algo dnssecAlgorithm) (pub []byte, signer crypto.Signer, err error) {
+	switch algo {
+	case dnssecAlgorithmED25519:
+		p, priv, err := ed25519.GenerateKey(cryptorand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, priv, nil
+	case dnssecAlgorithmRSASHA256:
+		priv, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rsaPublicKeyRDATA(&priv.PublicKey), priv, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported DNSSEC algorithm %d", algo)
+	}
+}
+
+// rsaPublicKeyRDATA encodes an RSA public key per RFC 3110.
+func rsaPublicKeyRDATA(pub *rsa.PublicKey) []byte {
+	e := big64(pub.E)
+	var buf bytes.Buffer
+	if len(e) <= 255 {
+		buf.WriteByte(byte(len(e)))
+	} else {
+		buf.WriteByte(0)
+		binary.Write(&buf, binary.BigEndian, uint16(len(e)))
+	}
+	buf.Write(e)
+	buf.Write(pub.N.Bytes())
+	return buf.Bytes()
+}
+
+func big64(i int) []byte {
+	b := make([]byte, 0, 4)
+	started := false
+	for shift := 24; shift >= 0; shift -= 8 {
+		v := byte(i >> shift)
+		if v != 0 {
+			started = true
+		}
+		if started {
+			b = append(b, v)
+		}
+	}
+	if len(b) == 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// dnskeyRDATA builds the wire-format DNSKEY RDATA: flags, protocol, algorithm, public key.
+func dnskeyRDATA(flags uint16, algo dnssecAlgorithm, pub []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, flags)
+	buf.WriteByte(3) // protocol, always 3
+	buf.WriteByte(byte(algo))
+	buf.Write(pub)
+	return buf.Bytes()
+}
+
+// dnskeyKeyTag computes the key tag of a DNSKEY RDATA per RFC 4034 Appendix B.
+func dnskeyKeyTag(rdata []byte) uint16 {
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += (ac >> 16) & 0xFFFF
+	return uint16(ac & 0xFFFF)
+}
+
+// dsRDATAFromDNSKEY builds a DS RDATA (digest type 2, SHA-256) for the given owner/DNSKEY.
+func dsRDATAFromDNSKEY(owner string, dnskey []byte) []byte {
+	name := canonicalNameBytes(owner)
+	h := sha256.New()
+	h.Write(name)
+	h.Write(dnskey)
+	digest := h.Sum(nil)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, dnskeyKeyTag(dnskey))
+	buf.WriteByte(byte(dnssecAlgo))
+	buf.WriteByte(2) // digest type, SHA-256
+	buf.Write(digest)
+	return buf.Bytes()
+}
+
+// packDomainName returns the wire-format encoding of name: a sequence of length-prefixed
+// labels terminated by the zero-length root label. dnsmessage.Name.Data is just the literal
+// dotted string ("example.com."), not this encoding -- the method that actually produces it,
+// Name.pack, is unexported -- so anything that needs a name's wire bytes outside of a
+// dnsmessage.Builder call (RRSIG signing input, DS digest input, RDATA carrying a name) has
+// to pack it itself.
+func packDomainName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+
+	var buf bytes.Buffer
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 {
+				return nil, fmt.Errorf("dns: name %q has an empty label", name)
+			}
+			if len(label) > 63 {
+				return nil, fmt.Errorf("dns: label %q in %q is longer than 63 bytes", label, name)
+			}
+			buf.WriteByte(byte(len(label)))
+			buf.WriteString(label)
+		}
+	}
+	buf.WriteByte(0)
+
+	if buf.Len() > 255 {
+		return nil, fmt.Errorf("dns: name %q is longer than 255 bytes packed", name)
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalNameBytes returns the lowercased wire encoding of name, as required for RRSIG
+// signature input and owner-name hashing. Every caller passes one of this server's own fixed
+// zone/signer constants, so a packing error here means one of those constants is malformed,
+// not that bad input reached us -- that's a programmer error worth panicking on.
+func canonicalNameBytes(name string) []byte {
+	packed, err := packDomainName(strings.ToLower(name))
+	if err != nil {
+		panic("dnssec: " + err.Error())
+	}
+	return packed
+}
+
+// signRRSet produces the RRSIG RDATA covering an RRset (one or more records sharing an
+// owner/type/class/ttl), using signer/keyTag for the key and signerName as the RRSIG Signer's
+// Name field. Per RFC 4034 section 6.3, records within the set are hashed in canonical
+// (ascending RDATA) order, so rdataSet is sorted before signing regardless of call order.
+func signRRSet(owner dnsmessage.Name, rrtype dnsmessage.Type, ttl uint32, rdataSet [][]byte, signer crypto.Signer, keyTag uint16, signerName string, inception, expiration time.Time) []byte {
+	var toSign bytes.Buffer
+
+	labels := uint8(strings.Count(strings.TrimSuffix(owner.String(), "."), "."))
+	if labels == 0 && owner.String() != "." {
+		labels = 1
+	} else {
+		labels++
+	}
+
+	// RRSIG RDATA minus the signature itself
+	binary.Write(&toSign, binary.BigEndian, uint16(rrtype))
+	toSign.WriteByte(byte(dnssecAlgo))
+	toSign.WriteByte(labels)
+	binary.Write(&toSign, binary.BigEndian, ttl)
+	binary.Write(&toSign, binary.BigEndian, uint32(expiration.Unix()))
+	binary.Write(&toSign, binary.BigEndian, uint32(inception.Unix()))
+	binary.Write(&toSign, binary.BigEndian, keyTag)
+	toSign.Write(canonicalNameBytes(signerName))
+	rrsigRDATAPrefix := append([]byte{}, toSign.Bytes()...)
+
+	// then each RR in the set, in canonical form
+	sorted := append([][]byte{}, rdataSet...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	ownerBytes, err := packDomainName(strings.ToLower(owner.String()))
+	if err != nil {
+		return nil
+	}
+	for _, rdata := range sorted {
+		toSign.Write(ownerBytes)
+		binary.Write(&toSign, binary.BigEndian, uint16(rrtype))
+		binary.Write(&toSign, binary.BigEndian, uint16(dnsmessage.ClassINET))
+		binary.Write(&toSign, binary.BigEndian, ttl)
+		binary.Write(&toSign, binary.BigEndian, uint16(len(rdata)))
+		toSign.Write(rdata)
+	}
+
+	signature, err := signDNSSEC(signer, toSign.Bytes())
+	if err != nil {
+		return nil
+	}
+
+	return append(rrsigRDATAPrefix, signature...)
+}
+
+func signDNSSEC(signer crypto.Signer, message []byte) ([]byte, error) {
+	switch key := signer.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, message), nil
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(message)
+		return rsa.SignPKCS1v15(cryptorand.Reader, key, crypto.SHA256, digest[:])
+	default:
+		return nil, fmt.Errorf("unsupported signer type %T", signer)
+	}
+}
+
+// dnssecValidityWindow returns a signature inception/expiration pair that's valid as of now,
+// so "valid" test signatures don't go stale as wall-clock time moves on. Shared by dnssecRRSIGFor
+// and signScenarioRRSet so there's one place that defines what "currently valid" means.
+func dnssecValidityWindow() (inception, expiration time.Time) {
+	now := time.Now()
+	return now.Add(-1 * time.Hour), now.Add(30 * 24 * time.Hour)
+}
+
+// dnssecRRSIGFor signs a single answer RR for one of the dnssec.* test names, perturbing the
+// result in whatever way that test name calls for.
+func dnssecRRSIGFor(testName string, owner dnsmessage.Name, rrtype dnsmessage.Type, ttl uint32, rdata []byte) dnsmessage.UnknownResource {
+	inception, expiration := dnssecValidityWindow()
+	signerName := dnssecZoneName
+
+	switch testName {
+	case TestNameDNSSECExpired:
+		inception = time.Now().Add(-48 * time.Hour)
+		expiration = time.Now().Add(-24 * time.Hour)
+	case TestNameDNSSECWrongSigner:
+		signerName = dnssecWrongSigner
+	}
+
+	sig := signRRSet(owner, rrtype, ttl, [][]byte{rdata}, zskSigner, zskTag, signerName, inception, expiration)
+
+	if testName == TestNameDNSSECBadSig && len(sig) > 0 {
+		sig[len(sig)-1] ^= 0xFF
+	}
+
+	return dnsmessage.UnknownResource{Type: dnsTypeRRSIG, Data: sig}
+}
+
+// dnskeyRRSIG signs the DNSKEY RRset (ZSK + KSK) at owner with the KSK, so a validator that's
+// confirmed the KSK against the DS record has an authenticated basis to also trust the ZSK
+// published alongside it, completing the chain down to the per-answer RRSIGs.
+func dnskeyRRSIG(owner dnsmessage.Name) dnsmessage.UnknownResource {
+	inception, expiration := dnssecValidityWindow()
+	sig := signRRSet(owner, dnsTypeDNSKEY, 0, [][]byte{zskRDATA, kskRDATA}, kskSigner, kskTag, dnssecZoneName, inception, expiration)
+	return dnsmessage.UnknownResource{Type: dnsTypeRRSIG, Data: sig}
+}
+
+// isDNSSECTestName reports whether testName is one of the dnssec.* test names whose answer
+// carries an RRSIG. TestNameDNSSECNSEC3Missing is not one of them: it gets no answer to sign.
+func isDNSSECTestName(testName string) bool {
+	switch testName {
+	case TestNameDNSSECValid, TestNameDNSSECBadSig, TestNameDNSSECExpired, TestNameDNSSECWrongSigner:
+		return true
+	default:
+		return false
+	}
+}
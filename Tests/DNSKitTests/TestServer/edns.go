@@ -0,0 +1,174 @@
+/*
+DNSKit
+Copyright (C) Ian Spence and other DNSKit Contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Lesser General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Lesser General Public License for more details.
+
+You should have received a copy of the GNU Lesser General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS names used to force specific Extended DNS Error (RFC 8914) option codes
+const (
+	TestNameEDEDNSSECBogus  = "ede.dnssec-bogus.example.com."
+	TestNameEDEStale        = "ede.stale.example.com."
+	TestNameEDEBlocked      = "ede.blocked.example.com."
+	TestNameEDENetworkError = "ede.network-error.example.com."
+
+	// UDP only: forces a reply advertising a smaller payload size than was requested,
+	// with TC=1 set, so the client is forced to retry over TCP/DoT/DoQ.
+	TestNameEDNSTruncate = "truncate.udp.example.com."
+
+	// TestNameBadVers forces a BADVERS (16) RCode, which doesn't fit the base header's 4-bit
+	// RCODE field and so only ever appears correctly by way of the OPT record's extended
+	// RCODE byte -- the one case that actually exercises it.
+	TestNameBadVers = "badvers.example.com."
+)
+
+// ednsOptionCodeEDE is the IANA-assigned EDNS option code for Extended DNS Error.
+const ednsOptionCodeEDE = 15
+
+// serverUDPPayloadSize is the maximum UDP payload size this server ever advertises in its
+// own OPT records, regardless of what the client requested.
+const serverUDPPayloadSize = 512
+
+// edeCodeForTestName maps an ede.* test name to the Extended DNS Error INFO-CODE it exercises.
+func edeCodeForTestName(testName string) (uint16, bool) {
+	switch testName {
+	case TestNameEDEDNSSECBogus:
+		return 6, true
+	case TestNameEDEStale:
+		return 3, true
+	case TestNameEDEBlocked:
+		return 15, true
+	case TestNameEDENetworkError:
+		return 20, true
+	default:
+		return 0, false
+	}
+}
+
+// ednsRequest describes the client's OPT record, if it sent one.
+type ednsRequest struct {
+	present bool
+	udpSize uint16
+	do      bool
+}
+
+// parseEDNS re-parses a raw DNS query looking for the client's OPT record.
+func parseEDNS(in []byte) ednsRequest {
+	p := dnsmessage.Parser{}
+	if _, err := p.Start(in); err != nil {
+		return ednsRequest{}
+	}
+	if _, err := p.AllQuestions(); err != nil {
+		return ednsRequest{}
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return ednsRequest{}
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return ednsRequest{}
+	}
+	additionals, err := p.AllAdditionals()
+	if err != nil {
+		return ednsRequest{}
+	}
+	for _, a := range additionals {
+		if a.Header.Type == dnsmessage.TypeOPT {
+			return ednsRequest{
+				present: true,
+				udpSize: uint16(a.Header.Class),
+				do:      (a.Header.TTL>>15)&1 == 1,
+			}
+		}
+	}
+	return ednsRequest{}
+}
+
+// ednsOptionEDE builds the RDATA of an Extended DNS Error option: a 2-byte INFO-CODE
+// followed by a free-form EXTRA-TEXT string derived from the query name.
+func ednsOptionEDE(infoCode uint16, extraText string) dnsmessage.Option {
+	data := make([]byte, 2+len(extraText))
+	data[0] = byte(infoCode >> 8)
+	data[1] = byte(infoCode)
+	copy(data[2:], extraText)
+	return dnsmessage.Option{Code: ednsOptionCodeEDE, Data: data}
+}
+
+// appendOPTResource adds an OPT RR to the response's additional section when the client's
+// query included one, carrying an Extended DNS Error option for ede.* test names and the
+// extended RCODE byte when rcode needs more than 4 bits.
+func appendOPTResource(b *dnsmessage.Builder, req ednsRequest, testName string, rcode dnsmessage.RCode) error {
+	if !req.present {
+		return nil
+	}
+
+	if err := b.StartAdditionals(); err != nil {
+		return err
+	}
+
+	var options []dnsmessage.Option
+	if infoCode, ok := edeCodeForTestName(testName); ok {
+		options = append(options, ednsOptionEDE(infoCode, testName))
+	}
+
+	extendedRCode := uint32(rcode) >> 4
+	ttl := extendedRCode << 24
+
+	return b.OPTResource(dnsmessage.ResourceHeader{
+		Name:  dnsmessage.MustNewName("."),
+		Class: dnsmessage.Class(serverUDPPayloadSize),
+		TTL:   ttl,
+	}, dnsmessage.OPTResource{Options: options})
+}
+
+// truncatedReply builds a TC=1 response with no answers, simulating a resolver that
+// refuses to send a full UDP reply because the client's requested payload size is too
+// small. Clients are expected to retry over a stream transport.
+func truncatedReply(in []byte) ([]byte, error) {
+	p := dnsmessage.Parser{}
+	header, err := p.Start(in)
+	if err != nil {
+		return nil, err
+	}
+
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	header.Response = true
+	header.RCode = dnsmessage.RCodeSuccess
+	header.Truncated = true
+
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, question := range questions {
+		if err := b.Question(question); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := appendOPTResource(&b, parseEDNS(in), TestNameEDNSTruncate, header.RCode); err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
@@ -32,6 +32,8 @@ func main() {
 	rootCertPath := ""
 	rootKeyPath := ""
 	servername := "localhost"
+	dnssecAlgoName := ""
+	scenariosPath := ""
 
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
@@ -89,6 +91,20 @@ func main() {
 			}
 			servername = args[i+1]
 			i++
+		case "--dnssec-algo":
+			if i == len(args)-1 {
+				fmt.Fprintf(os.Stderr, "Argument %s requires a value\n", arg)
+				os.Exit(1)
+			}
+			dnssecAlgoName = args[i+1]
+			i++
+		case "--scenarios":
+			if i == len(args)-1 {
+				fmt.Fprintf(os.Stderr, "Argument %s requires a value\n", arg)
+				os.Exit(1)
+			}
+			scenariosPath = args[i+1]
+			i++
 		default:
 			fmt.Printf(`Usage: %s <Options>
 Required options:
@@ -101,6 +117,8 @@ Optional options:
 --bind-ipv4 <value>               Specify the IPv4 address to bind to. Defaults to 127.0.0.1.
 --bind-ipv6 <value>               Specify the IPv6 address to bind to. Defaults to ::1.
 --servername <value>              Specify the servername for TLS servers & certificates. Defaults to localhost.
+--dnssec-algo <value>             Specify the DNSSEC signing algorithm, "ed25519" or "rsasha256". Defaults to ed25519.
+--scenarios <value>               Specify the path to a scenario YAML file. Defaults to the built-in scenarios. Reloaded on SIGHUP.
 `, os.Args[0])
 			os.Exit(1)
 		}
@@ -119,6 +137,20 @@ Optional options:
 		panic(err)
 	}
 
+	algo, err := dnssecAlgorithmFromString(dnssecAlgoName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := initDNSSEC(algo); err != nil {
+		panic(err)
+	}
+
+	if err := loadScenarios(scenariosPath); err != nil {
+		panic(err)
+	}
+	watchScenariosReload(scenariosPath)
+
 	start(startPort, bindIP4, bindIP6, servername)
 }
 
@@ -129,6 +161,8 @@ func start(startPort uint16, ipv4 string, ipv6 string, servername string) {
 		&tserverDNSOverHTTPS{},
 		&tserverDNSOverTLS{},
 		&tserverDNSOverQuic{},
+		&tserverDNSOverGRPC{},
+		&tserverDNSOverHTTP3{},
 	}
 
 	port := startPort
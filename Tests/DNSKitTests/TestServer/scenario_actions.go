@@ -0,0 +1,275 @@
+/*
+DNSKit
+Copyright (C) Ian Spence and other DNSKit Contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Lesser General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Lesser General Public License for more details.
+
+You should have received a copy of the GNU Lesser General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resource record types used by scenario answers. All but SRV are types that dnsmessage
+// doesn't predefine.
+const (
+	dnsTypeSRV  = dnsmessage.TypeSRV
+	dnsTypeCAA  = dnsmessage.Type(257)
+	dnsTypeSVCB = dnsmessage.Type(64)
+	dnsTypeTLSA = dnsmessage.Type(52)
+)
+
+// qtypeName returns the scenario-file spelling of a query type, e.g. "A" rather than the
+// "TypeA" dnsmessage.Type.String() produces.
+func qtypeName(t dnsmessage.Type) string {
+	return strings.TrimPrefix(t.String(), "Type")
+}
+
+// queryQType parses in just far enough to report its question's type, for scenario
+// matching by transports that haven't already parsed the query themselves.
+func queryQType(in []byte) string {
+	p := dnsmessage.Parser{}
+	if _, err := p.Start(in); err != nil {
+		return ""
+	}
+	questions, err := p.AllQuestions()
+	if err != nil || len(questions) != 1 {
+		return ""
+	}
+	return qtypeName(questions[0].Type)
+}
+
+// rcodeFromString maps the scenario "rcode" field to a dnsmessage.RCode.
+func rcodeFromString(s string) dnsmessage.RCode {
+	switch strings.ToUpper(s) {
+	case "NOERROR", "":
+		return dnsmessage.RCodeSuccess
+	case "FORMERR":
+		return dnsmessage.RCodeFormatError
+	case "SERVFAIL":
+		return dnsmessage.RCodeServerFailure
+	case "NXDOMAIN":
+		return dnsmessage.RCodeNameError
+	case "NOTIMP":
+		return dnsmessage.RCodeNotImplemented
+	case "REFUSED":
+		return dnsmessage.RCodeRefused
+	case "BADVERS":
+		// 16: doesn't fit the base header's 4-bit RCODE field, so this is the only rcode
+		// value that actually exercises the OPT record's extended RCODE byte.
+		return dnsmessage.RCode(16)
+	default:
+		return dnsmessage.RCodeServerFailure
+	}
+}
+
+// scenarioAnswerRDATA encodes ans as wire-format RDATA for the record type it names.
+func scenarioAnswerRDATA(ans scenarioAnswer) (dnsmessage.Type, []byte, error) {
+	switch strings.ToUpper(ans.Type) {
+	case "A":
+		ip := net.ParseIP(ans.Value).To4()
+		if ip == nil {
+			return 0, nil, fmt.Errorf("scenario: invalid A value %q", ans.Value)
+		}
+		return dnsmessage.TypeA, []byte(ip), nil
+	case "AAAA":
+		ip := net.ParseIP(ans.Value).To16()
+		if ip == nil {
+			return 0, nil, fmt.Errorf("scenario: invalid AAAA value %q", ans.Value)
+		}
+		return dnsmessage.TypeAAAA, []byte(ip), nil
+	case "NS":
+		target, err := nameRDATA(ans.Value)
+		if err != nil {
+			return 0, nil, err
+		}
+		return dnsmessage.TypeNS, target, nil
+	case "CNAME":
+		target, err := nameRDATA(ans.Value)
+		if err != nil {
+			return 0, nil, err
+		}
+		return dnsmessage.TypeCNAME, target, nil
+	case "MX":
+		fields := strings.Fields(ans.Value)
+		if len(fields) != 2 {
+			return 0, nil, fmt.Errorf("scenario: MX value must be \"priority exchange\", got %q", ans.Value)
+		}
+		priority, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return 0, nil, err
+		}
+		exchange, err := nameRDATA(fields[1])
+		if err != nil {
+			return 0, nil, err
+		}
+		rdata := make([]byte, 2)
+		binary.BigEndian.PutUint16(rdata, uint16(priority))
+		return dnsmessage.TypeMX, append(rdata, exchange...), nil
+	case "TXT":
+		if len(ans.Value) > 255 {
+			return 0, nil, fmt.Errorf("scenario: TXT value longer than 255 bytes")
+		}
+		return dnsmessage.TypeTXT, append([]byte{byte(len(ans.Value))}, ans.Value...), nil
+	case "SRV":
+		fields := strings.Fields(ans.Value)
+		if len(fields) != 4 {
+			return 0, nil, fmt.Errorf("scenario: SRV value must be \"priority weight port target\", got %q", ans.Value)
+		}
+		rdata := make([]byte, 6)
+		for i, f := range fields[:3] {
+			v, err := strconv.ParseUint(f, 10, 16)
+			if err != nil {
+				return 0, nil, err
+			}
+			binary.BigEndian.PutUint16(rdata[i*2:], uint16(v))
+		}
+		target, err := nameRDATA(fields[3])
+		if err != nil {
+			return 0, nil, err
+		}
+		return dnsTypeSRV, append(rdata, target...), nil
+	case "CAA":
+		fields := strings.SplitN(ans.Value, " ", 3)
+		if len(fields) != 3 {
+			return 0, nil, fmt.Errorf("scenario: CAA value must be \"flag tag value\", got %q", ans.Value)
+		}
+		flag, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return 0, nil, err
+		}
+		rdata := []byte{byte(flag), byte(len(fields[1]))}
+		rdata = append(rdata, fields[1]...)
+		rdata = append(rdata, fields[2]...)
+		return dnsTypeCAA, rdata, nil
+	case "TLSA":
+		fields := strings.Fields(ans.Value)
+		if len(fields) != 4 {
+			return 0, nil, fmt.Errorf("scenario: TLSA value must be \"usage selector matchtype hexdata\", got %q", ans.Value)
+		}
+		cert, err := hex.DecodeString(fields[3])
+		if err != nil {
+			return 0, nil, err
+		}
+		usage, _ := strconv.ParseUint(fields[0], 10, 8)
+		selector, _ := strconv.ParseUint(fields[1], 10, 8)
+		matchType, _ := strconv.ParseUint(fields[2], 10, 8)
+		rdata := []byte{byte(usage), byte(selector), byte(matchType)}
+		return dnsTypeTLSA, append(rdata, cert...), nil
+	case "SVCB", "HTTPS":
+		fields := strings.Fields(ans.Value)
+		if len(fields) != 2 {
+			return 0, nil, fmt.Errorf("scenario: %s value must be \"priority target\", got %q", ans.Type, ans.Value)
+		}
+		priority, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return 0, nil, err
+		}
+		target, err := nameRDATA(fields[1])
+		if err != nil {
+			return 0, nil, err
+		}
+		rdata := make([]byte, 2)
+		binary.BigEndian.PutUint16(rdata, uint16(priority))
+		rdata = append(rdata, target...)
+		if strings.EqualFold(ans.Type, "HTTPS") {
+			return dnsmessage.Type(65), rdata, nil
+		}
+		return dnsTypeSVCB, rdata, nil
+	default:
+		return 0, nil, fmt.Errorf("scenario: unsupported answer type %q", ans.Type)
+	}
+}
+
+// nameRDATA returns the wire encoding of a domain name as it appears inside another record's
+// RDATA (length-prefixed labels, zero-terminated -- see packDomainName). name comes straight
+// from an admin-supplied, hot-reloadable scenario file, so a malformed value is reported back
+// as an error rather than taken down the whole process with a panic.
+func nameRDATA(name string) ([]byte, error) {
+	return packDomainName(name)
+}
+
+// buildScenarioReply builds a full reply driven entirely by a scenario match, used for the
+// "answer", "sign-with", "rcode" and "truncate" actions which each produce a complete
+// message rather than tweaking framing at the transport layer.
+func buildScenarioReply(in []byte, header dnsmessage.Header, questions []dnsmessage.Question, ednsReq ednsRequest, testName string, s scenario) ([]byte, error) {
+	if s.Action == "truncate" {
+		return truncatedReply(in)
+	}
+
+	header.Response = true
+	header.RCode = dnsmessage.RCodeSuccess
+	if s.Action == "rcode" {
+		header.RCode = rcodeFromString(s.RCode)
+	}
+
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	owner := questions[0].Name
+	for _, ans := range s.Answer {
+		rrtype, rdata, err := scenarioAnswerRDATA(ans)
+		if err != nil {
+			return nil, err
+		}
+		rh := dnsmessage.ResourceHeader{Name: owner, Type: rrtype, Class: dnsmessage.ClassINET, TTL: ans.TTL}
+		if err := b.UnknownResource(rh, dnsmessage.UnknownResource{Type: rrtype, Data: rdata}); err != nil {
+			return nil, err
+		}
+		if s.Action == "sign-with" && ednsReq.do {
+			rrsig := signScenarioRRSet(owner, rrtype, ans.TTL, rdata, s.KeyID)
+			rh := dnsmessage.ResourceHeader{Name: owner, Type: dnsTypeRRSIG, Class: dnsmessage.ClassINET, TTL: ans.TTL}
+			if err := b.UnknownResource(rh, dnsmessage.UnknownResource{Type: dnsTypeRRSIG, Data: rrsig}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := appendOPTResource(&b, ednsReq, testName, header.RCode); err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// signScenarioRRSet signs an RRset on behalf of a "sign-with" scenario action, picking the
+// ZSK or KSK by keyID ("zsk"/"ksk", defaulting to the ZSK) with a signature window that's
+// valid at the time it's generated.
+func signScenarioRRSet(owner dnsmessage.Name, rrtype dnsmessage.Type, ttl uint32, rdata []byte, keyID string) []byte {
+	signer, keyTag := zskSigner, zskTag
+	if strings.EqualFold(keyID, "ksk") {
+		signer, keyTag = kskSigner, kskTag
+	}
+
+	inception, expiration := dnssecValidityWindow()
+	return signRRSet(owner, rrtype, ttl, [][]byte{rdata}, signer, keyTag, dnssecZoneName, inception, expiration)
+}
@@ -0,0 +1,157 @@
+/*
+DNSKit
+Copyright (C) Ian Spence and other DNSKit Contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Lesser General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Lesser General Public License for more details.
+
+You should have received a copy of the GNU Lesser General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed scenarios_default.yaml
+var defaultScenariosYAML []byte
+
+// errScenarioDrop is returned by handleDNSQuery for the "drop" action. The UDP, TCP, DoT and
+// DoQ transports treat a non-nil handleDNSQuery error as "send nothing back", so they don't
+// special-case it; DoH answers it with HTTP 400 and gRPC with an InvalidArgument status.
+var errScenarioDrop = fmt.Errorf("scenario: drop")
+
+type scenarioMatch struct {
+	Name      string `yaml:"name"`
+	QType     string `yaml:"qtype,omitempty"`
+	Transport string `yaml:"transport,omitempty"`
+}
+
+type scenarioAnswer struct {
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+	TTL   uint32 `yaml:"ttl,omitempty"`
+}
+
+// scenario is one entry of a scenario file: a match block plus the action to take when a
+// query hits it. Only the fields relevant to Action need to be set.
+type scenario struct {
+	Match       scenarioMatch    `yaml:"match"`
+	Action      string           `yaml:"action"`
+	Answer      []scenarioAnswer `yaml:"answer,omitempty"`
+	RCode       string           `yaml:"rcode,omitempty"`
+	Delay       string           `yaml:"delay,omitempty"`
+	Bytes       int              `yaml:"bytes,omitempty"`
+	Delta       int              `yaml:"delta,omitempty"`
+	ContentType string           `yaml:"content_type,omitempty"`
+	ChunkSizes  []int            `yaml:"chunk_sizes,omitempty"`
+	KeyID       string           `yaml:"key_id,omitempty"`
+}
+
+type scenarioFile struct {
+	Scenarios []scenario `yaml:"scenarios"`
+}
+
+var (
+	scenariosMu     sync.RWMutex
+	loadedScenarios []scenario
+)
+
+// loadScenarios parses scenarios from path, or the built-in defaults when path is empty,
+// and atomically swaps them in for resolveScenario.
+func loadScenarios(path string) error {
+	data := defaultScenariosYAML
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data = b
+	}
+
+	var file scenarioFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	if err := validateScenarios(file.Scenarios); err != nil {
+		return err
+	}
+
+	scenariosMu.Lock()
+	loadedScenarios = file.Scenarios
+	scenariosMu.Unlock()
+	return nil
+}
+
+// validateScenarios checks that every answer value in scenarios can actually be encoded,
+// so a config mistake (an oversized CNAME target, say) is rejected at load/reload time with
+// a clear error instead of only surfacing later as a failure on whichever query hits it.
+func validateScenarios(scenarios []scenario) error {
+	for i, s := range scenarios {
+		for _, ans := range s.Answer {
+			if _, _, err := scenarioAnswerRDATA(ans); err != nil {
+				return fmt.Errorf("scenario %d (%s): %w", i, s.Match.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// watchScenariosReload reloads scenarios from path every time the process receives SIGHUP,
+// so a scenario file can be edited without restarting the server.
+func watchScenariosReload(path string) {
+	if path == "" {
+		return
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			if err := loadScenarios(path); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reload scenarios from %s: %s\n", path, err.Error())
+				continue
+			}
+			fmt.Printf("Reloaded scenarios from %s\n", path)
+		}
+	}()
+}
+
+// resolveScenario finds the first loaded scenario whose match block applies to name/qtype/
+// transport. An empty qtype or transport in the match block matches any value.
+func resolveScenario(name string, qtype string, transport string) (scenario, bool) {
+	scenariosMu.RLock()
+	defer scenariosMu.RUnlock()
+
+	for _, s := range loadedScenarios {
+		if !strings.EqualFold(s.Match.Name, name) {
+			continue
+		}
+		if s.Match.QType != "" && !strings.EqualFold(s.Match.QType, qtype) {
+			continue
+		}
+		if s.Match.Transport != "" && !strings.EqualFold(s.Match.Transport, transport) {
+			continue
+		}
+		return s, true
+	}
+
+	return scenario{}, false
+}
@@ -0,0 +1,164 @@
+/*
+DNSKit
+Copyright (C) Ian Spence and other DNSKit Contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Lesser General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Lesser General Public License for more details.
+
+You should have received a copy of the GNU Lesser General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// RawMsg is the dnss.DNS.Query request/response message: a single field carrying the
+// wire-format DNS message, byte for byte identical to what the UDP handler consumes.
+type RawMsg struct {
+	Data []byte
+}
+
+// rawCodec marshals RawMsg as its raw Data bytes instead of going through protobuf
+// reflection, which lets this test server speak the dnss.DNS service without a protoc
+// code-generation step. It registers under the "proto" name, which is the content-subtype
+// gRPC assumes when none is negotiated, so it transparently replaces the default codec.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*RawMsg)
+	if !ok {
+		return nil, fmt.Errorf("grpc: unsupported message type %T", v)
+	}
+	return m.Data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*RawMsg)
+	if !ok {
+		return fmt.Errorf("grpc: unsupported message type %T", v)
+	}
+	m.Data = append([]byte{}, data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
+
+func init() {
+	encoding.RegisterCodec(rawCodec{})
+}
+
+// dnssServiceDesc describes the dnss.DNS service: a single unary RPC, Query(RawMsg)
+// returns (RawMsg), modeled on the dnss project's DNS-to-gRPC proxy.
+var dnssServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dnss.DNS",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    dnssQueryHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "dnss.proto",
+}
+
+func dnssQueryHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawMsg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(*tserverDNSOverGRPC).query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/dnss.DNS/Query"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*tserverDNSOverGRPC).query(ctx, req.(*RawMsg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+type tserverDNSOverGRPC struct{}
+
+func (s *tserverDNSOverGRPC) Start(port uint16, ipv4 string, ipv6 string, servername string) error {
+	chain, _, err := generateCertificateChain("DNSOverGRPC", 1, port, ipv4, ipv6, servername, nil)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*chain},
+		RootCAs:      rootCAPool,
+		ServerName:   servername,
+	}
+	// grpc.Creds performs its own TLS handshake on whatever net.Listener it's given, so these
+	// must stay plain TCP listeners -- wrapping them in tls.Listen as well would make grpc-go
+	// attempt a second handshake on top of the first and every client connection would hang.
+	t4l, err := net.Listen("tcp4", fmt.Sprintf("%s:%d", ipv4, port))
+	if err != nil {
+		return err
+	}
+	t6l, err := net.Listen("tcp6", fmt.Sprintf("[%s]:%d", ipv6, port))
+	if err != nil {
+		return err
+	}
+
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	grpcServer.RegisterService(&dnssServiceDesc, s)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	var grpcErr error
+
+	go func() {
+		if err := grpcServer.Serve(t4l); err != nil {
+			grpcErr = err
+		}
+		wg.Done()
+	}()
+	go func() {
+		if err := grpcServer.Serve(t6l); err != nil {
+			grpcErr = err
+		}
+		wg.Done()
+	}()
+
+	fmt.Printf("DNSGRPC ready on %s:%d, [%s]:%d\n", ipv4, port, ipv6, port)
+	wg.Wait()
+	return grpcErr
+}
+
+func (s *tserverDNSOverGRPC) query(ctx context.Context, in *RawMsg) (*RawMsg, error) {
+	testName := getDNSTestName(in.Data)
+	log.Printf("GRPC: %s", testName)
+
+	if testName == TestNameRandomData {
+		return nil, status.Error(codes.Unavailable, "simulated transport failure")
+	}
+
+	response, err := handleDNSQuery(in.Data, "grpc")
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &RawMsg{Data: response}, nil
+}
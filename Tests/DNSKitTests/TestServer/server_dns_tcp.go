@@ -87,29 +87,29 @@ func (s *tserverDNSTCP) Handle(conn net.Conn) {
 
 	testName := getDNSTestName(dataBuf)
 	log.Printf("TCP: %s", testName)
+	scn, hasScenario := resolveScenario(testName, queryQType(dataBuf), "tcp")
 
-	if testName == TestNameRandomData {
-		data := make([]byte, 265)
+	if hasScenario && scn.Action == "random-bytes" {
+		n := scn.Bytes
+		if n <= 0 {
+			n = 265
+		}
+		data := make([]byte, n)
 		rand.Read(data)
 		conn.Write(data)
 		conn.Close()
 		return
 	}
 
-	response, err := handleDNSQuery(dataBuf)
+	response, err := handleDNSQuery(dataBuf, "tcp")
 	if err != nil {
 		conn.Close()
 		return
 	}
 
-	var replyLength uint16
-
-	if testName == TestNameLengthOver {
-		replyLength = uint16(length + 32)
-	} else if testName == TestNameLengthUnder {
-		replyLength = uint16(length - 32)
-	} else {
-		replyLength = uint16(len(response))
+	replyLength := uint16(len(response))
+	if hasScenario && scn.Action == "override-length" {
+		replyLength = uint16(int(length) + scn.Delta)
 	}
 
 	lenBuf = make([]byte, 2)
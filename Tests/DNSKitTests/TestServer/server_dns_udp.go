@@ -75,14 +75,27 @@ func (s *tserverDNSUDP) Handle(message []byte, conn net.PacketConn, addr net.Add
 	testName := getDNSTestName(message)
 	log.Printf("UDP: %s", testName)
 
-	if testName == TestNameRandomData {
-		data := make([]byte, 265)
+	if scn, ok := resolveScenario(testName, queryQType(message), "udp"); ok && scn.Action == "random-bytes" {
+		n := scn.Bytes
+		if n <= 0 {
+			n = 265
+		}
+		data := make([]byte, n)
 		rand.Read(data)
 		conn.WriteTo(data, addr)
 		return
 	}
 
-	response, err := handleDNSQuery(message)
+	if testName == TestNameEDNSTruncate {
+		response, err := truncatedReply(message)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(response, addr)
+		return
+	}
+
+	response, err := handleDNSQuery(message, "udp")
 	if err != nil {
 		return
 	}
@@ -18,13 +18,22 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/http2"
 )
 
 type tserverDNSOverHTTPS struct{}
@@ -39,6 +48,7 @@ func (s *tserverDNSOverHTTPS) Start(port uint16, ipv4 string, ipv6 string, serve
 		Certificates: []tls.Certificate{*chain},
 		RootCAs:      rootCAPool,
 		ServerName:   servername,
+		NextProtos:   []string{"h2", "http/1.1"},
 	}
 	t4l, err := tls.Listen("tcp4", fmt.Sprintf("%s:%d", ipv4, port), tlsConfig)
 	if err != nil {
@@ -49,18 +59,23 @@ func (s *tserverDNSOverHTTPS) Start(port uint16, ipv4 string, ipv6 string, serve
 		return err
 	}
 
+	httpServer := &http.Server{Handler: s, TLSConfig: tlsConfig}
+	if err := http2.ConfigureServer(httpServer, &http2.Server{}); err != nil {
+		return err
+	}
+
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	var httpError error
 
 	go func() {
-		if err := http.Serve(t4l, s); err != nil {
+		if err := httpServer.Serve(t4l); err != nil {
 			httpError = err
 		}
 		wg.Done()
 	}()
 	go func() {
-		if err := http.Serve(t6l, s); err != nil {
+		if err := httpServer.Serve(t6l); err != nil {
 			httpError = err
 		}
 		wg.Done()
@@ -77,22 +92,26 @@ func (s *tserverDNSOverHTTPS) ServeHTTP(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	base64Message := r.URL.Query().Get("dns")
-	if base64Message == "" {
-		rw.WriteHeader(400)
-		log.Printf("[DNSOverHTTPS] Missing dns query")
+	message, err := readDoHMessage(r)
+	if err != nil {
+		rw.WriteHeader(err.(*dohRequestError).status)
+		log.Printf("[DNSOverHTTPS] %s", err.Error())
 		return
 	}
 
-	message, err := base64.RawURLEncoding.DecodeString(base64Message)
-	if err != nil {
-		rw.WriteHeader(400)
-		log.Printf("[DNSOverHTTPS] Error decoding dns query base64: %s", err.Error())
+	testName := getDNSTestName(message)
+	log.Printf("HTTPS: %s (%s)", testName, r.Method)
+
+	if testName == TestNamePostOnly && r.Method != http.MethodPost {
+		rw.WriteHeader(405)
+		return
+	}
+	if testName == TestNameGetOnly && r.Method != http.MethodGet {
+		rw.WriteHeader(405)
 		return
 	}
 
-	testName := getDNSTestName(message)
-	log.Printf("HTTPS: %s", testName)
+	scn, hasScenario := resolveScenario(testName, queryQType(message), "doh")
 
 	var response []byte
 
@@ -100,7 +119,7 @@ func (s *tserverDNSOverHTTPS) ServeHTTP(rw http.ResponseWriter, r *http.Request)
 		response = make([]byte, 265)
 		rand.Read(response)
 	} else {
-		response, err = handleDNSQuery(message)
+		response, err = handleDNSQuery(message, "doh")
 		if err != nil {
 			rw.WriteHeader(400)
 			log.Printf("[DNSOverHTTPS] Error handling DNS query: %s", err.Error())
@@ -108,14 +127,198 @@ func (s *tserverDNSOverHTTPS) ServeHTTP(rw http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	if testName == TestBadContentType {
+	if hasScenario {
+		switch scn.Action {
+		case "bad-content-type":
+			rw.Header().Set("Content-Type", scn.ContentType)
+			writeDoHBody(rw, response)
+			return
+		case "chunked-write":
+			writeChunkedDoHResponse(rw, response, scn.ChunkSizes)
+			return
+		case "close-mid-response":
+			writeTruncatedDoHResponse(rw, response)
+			return
+		}
+	}
+
+	writeDoHResponse(rw, testName, response)
+}
+
+// dohRequestError carries the HTTP status readDoHMessage wants the caller to send back.
+type dohRequestError struct {
+	status int
+	msg    string
+}
+
+func (e *dohRequestError) Error() string { return e.msg }
+
+// readDoHMessage implements the GET and POST request forms of RFC 8484: GET carries the
+// message base64url-encoded in the "dns" query parameter, POST carries it verbatim in the
+// body with a Content-Type of application/dns-message.
+func readDoHMessage(r *http.Request) ([]byte, error) {
+	switch r.Method {
+	case http.MethodGet:
+		base64Message := r.URL.Query().Get("dns")
+		if base64Message == "" {
+			return nil, &dohRequestError{400, "Missing dns query parameter"}
+		}
+		message, err := base64.RawURLEncoding.DecodeString(base64Message)
+		if err != nil {
+			return nil, &dohRequestError{400, fmt.Sprintf("Error decoding dns query base64: %s", err.Error())}
+		}
+		return message, nil
+	case http.MethodPost:
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/dns-message" {
+			return nil, &dohRequestError{415, fmt.Sprintf("Unsupported POST Content-Type %q", r.Header.Get("Content-Type"))}
+		}
+		message, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, &dohRequestError{400, fmt.Sprintf("Error reading POST body: %s", err.Error())}
+		}
+		return message, nil
+	default:
+		return nil, &dohRequestError{405, fmt.Sprintf("Unsupported method %s", r.Method)}
+	}
+}
+
+// writeDoHResponse writes response with whatever Content-Type/Content-Encoding/framing
+// quirk testName calls for, so DNSKit's client can be exercised against each of them.
+func writeDoHResponse(rw http.ResponseWriter, testName string, response []byte) {
+	switch testName {
+	case TestBadContentType:
 		rw.Header().Set("Content-Type", "application/UWU-whats-THIS")
-	} else if testName == TestNoContentType {
-		//
-	} else {
+		writeDoHBody(rw, response)
+	case TestNoContentType:
+		writeDoHBody(rw, response)
+	case TestCharsetContentType:
+		rw.Header().Set("Content-Type", "application/dns-message; charset=utf-8")
+		writeDoHBody(rw, response)
+	case TestNameDNSJSON:
+		body := dnsJSONBody(response)
+		rw.Header().Set("Content-Type", "application/dns-json")
+		writeDoHBody(rw, body)
+	case TestNameGzipHeader, TestNameGzipNoHeader:
+		gzipped := gzipBytes(response)
+		if testName == TestNameGzipHeader {
+			rw.Header().Set("Content-Encoding", "gzip")
+		}
+		rw.Header().Set("Content-Type", "application/dns-message")
+		writeDoHBody(rw, gzipped)
+	case TestNameChunkedWrongLength:
 		rw.Header().Set("Content-Type", "application/dns-message")
+		// Deliberately wrong: the real body is shorter than this by 32 bytes.
+		rw.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)+32))
+		rw.WriteHeader(200)
+		flusher, _ := rw.(http.Flusher)
+		for i := 0; i < len(response); i += 16 {
+			end := min(i+16, len(response))
+			rw.Write(response[i:end])
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	default:
+		rw.Header().Set("Content-Type", "application/dns-message")
+		writeDoHBody(rw, response)
 	}
-	rw.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
+}
+
+func writeDoHBody(rw http.ResponseWriter, body []byte) {
+	rw.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	rw.WriteHeader(200)
+	rw.Write(body)
+}
+
+// writeChunkedDoHResponse writes body as a sequence of flushed writes sized by chunkSizes
+// (falling back to a single write of the whole body if none are given), for the
+// "chunked-write" scenario action.
+func writeChunkedDoHResponse(rw http.ResponseWriter, body []byte, chunkSizes []int) {
+	rw.Header().Set("Content-Type", "application/dns-message")
+	rw.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	rw.WriteHeader(200)
+	flusher, _ := rw.(http.Flusher)
+
+	if len(chunkSizes) == 0 {
+		rw.Write(body)
+		return
+	}
+
+	for i := 0; i < len(body); {
+		size := chunkSizes[0]
+		chunkSizes = chunkSizes[1:]
+		if len(chunkSizes) == 0 {
+			chunkSizes = append(chunkSizes, size)
+		}
+		end := min(i+size, len(body))
+		rw.Write(body[i:end])
+		if flusher != nil {
+			flusher.Flush()
+		}
+		i = end
+	}
+}
+
+// writeTruncatedDoHResponse declares the full body length but only writes half of it, so
+// net/http is left holding a short write and forcibly closes the connection once the handler
+// returns -- simulating a server that dies mid-response, for the "close-mid-response" action.
+func writeTruncatedDoHResponse(rw http.ResponseWriter, body []byte) {
+	rw.Header().Set("Content-Type", "application/dns-message")
+	rw.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
 	rw.WriteHeader(200)
-	rw.Write(response)
+	rw.Write(body[:len(body)/2])
+}
+
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+// dnsJSONBody converts a wire-format DNS message into a Google-style DoH JSON body
+// (application/dns-json): parseable, but a different shape than application/dns-message.
+func dnsJSONBody(wire []byte) []byte {
+	type jsonRR struct {
+		Name string `json:"name"`
+		Type uint16 `json:"type"`
+		TTL  uint32 `json:"TTL,omitempty"`
+		Data string `json:"data,omitempty"`
+	}
+	type jsonResponse struct {
+		Status   int      `json:"Status"`
+		Question []jsonRR `json:"Question"`
+		Answer   []jsonRR `json:"Answer,omitempty"`
+	}
+
+	p := dnsmessage.Parser{}
+	header, err := p.Start(wire)
+	if err != nil {
+		body, _ := json.Marshal(jsonResponse{Status: 2})
+		return body
+	}
+
+	resp := jsonResponse{Status: int(header.RCode)}
+
+	questions, _ := p.AllQuestions()
+	for _, q := range questions {
+		resp.Question = append(resp.Question, jsonRR{Name: q.Name.String(), Type: uint16(q.Type)})
+	}
+
+	answers, _ := p.AllAnswers()
+	for _, a := range answers {
+		rr := jsonRR{Name: a.Header.Name.String(), Type: uint16(a.Header.Type), TTL: a.Header.TTL}
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			rr.Data = net.IP(body.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			rr.Data = net.IP(body.AAAA[:]).String()
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+
+	body, _ := json.Marshal(resp)
+	return body
 }
@@ -0,0 +1,85 @@
+/*
+DNSKit
+Copyright (C) 2024 Ian Spence
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Lesser General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Lesser General Public License for more details.
+
+You should have received a copy of the GNU Lesser General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// tserverDNSOverHTTP3 is the DoH transport's HTTP/3 sibling: same /dns-query handler,
+// same RFC 8484 request/response rules, reached over QUIC instead of TCP+TLS. It embeds
+// tserverDNSOverHTTPS purely to reuse its ServeHTTP; it runs its own UDP listeners rather
+// than sharing the DoQ transport's socket, same as every other transport in this server
+// gets its own port.
+type tserverDNSOverHTTP3 struct {
+	tserverDNSOverHTTPS
+}
+
+func (s *tserverDNSOverHTTP3) Start(port uint16, ipv4 string, ipv6 string, servername string) error {
+	chain, _, err := generateCertificateChain("DNSOverHTTP3", 1, port, ipv4, ipv6, servername, nil)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*chain},
+		RootCAs:      rootCAPool,
+		ServerName:   servername,
+		NextProtos:   []string{"h3"},
+	}
+
+	pc4, err := net.ListenPacket("udp4", fmt.Sprintf("%s:%d", ipv4, port))
+	if err != nil {
+		return err
+	}
+	pc6, err := net.ListenPacket("udp6", fmt.Sprintf("[%s]:%d", ipv6, port))
+	if err != nil {
+		return err
+	}
+
+	h3Server := &http3.Server{
+		Handler:   s,
+		TLSConfig: tlsConfig,
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	var h3Error error
+
+	go func() {
+		if err := h3Server.Serve(pc4); err != nil {
+			h3Error = err
+		}
+		wg.Done()
+	}()
+	go func() {
+		if err := h3Server.Serve(pc6); err != nil {
+			h3Error = err
+		}
+		wg.Done()
+	}()
+
+	fmt.Printf("DNSHTTP3 ready on %s:%d, [%s]:%d\n", ipv4, port, ipv6, port)
+	wg.Wait()
+	return h3Error
+}
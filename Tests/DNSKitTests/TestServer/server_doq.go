@@ -136,29 +136,29 @@ func (s *tserverDNSOverQuic) Handle(conn *quic.Conn, rw *quic.Stream) {
 
 	testName := getDNSTestName(dataBuf)
 	log.Printf("Quic: %s", testName)
+	scn, hasScenario := resolveScenario(testName, queryQType(dataBuf), "doq")
 
-	if testName == TestNameRandomData {
-		data := make([]byte, 265)
+	if hasScenario && scn.Action == "random-bytes" {
+		n := scn.Bytes
+		if n <= 0 {
+			n = 265
+		}
+		data := make([]byte, n)
 		rand.Read(data)
 		rw.Write(data)
 		rw.Close()
 		return
 	}
 
-	response, err := handleDNSQuery(dataBuf)
+	response, err := handleDNSQuery(dataBuf, "doq")
 	if err != nil {
 		rw.Close()
 		return
 	}
 
-	var replyLength uint16
-
-	if testName == TestNameLengthOver {
-		replyLength = uint16(length + 32)
-	} else if testName == TestNameLengthUnder {
-		replyLength = uint16(length - 32)
-	} else {
-		replyLength = uint16(len(response))
+	replyLength := uint16(len(response))
+	if hasScenario && scn.Action == "override-length" {
+		replyLength = uint16(int(length) + scn.Delta)
 	}
 
 	lenBuf = make([]byte, 2)